@@ -18,6 +18,7 @@ package security
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
@@ -34,8 +35,16 @@ const (
 // connection originates from a client or another node in the cluster.
 type UserAuthHook func(string, bool) error
 
-// GetCertificateUser extract the username from a client certificate.
-func GetCertificateUser(tlsState *tls.ConnectionState) (string, error) {
+// GetCertificateUser extracts the username from a client certificate. If
+// idMap is non-nil, its rules are checked against the leaf certificate's
+// Subject Alternative Names (DNS, URI, and email) first; this is what lets
+// SPIFFE/Kubernetes-style workload certificates, whose CommonName is
+// typically a random pod name, map to a stable Cockroach user. Only if no
+// rule matches (or idMap is nil) do we fall back to Subject.CommonName,
+// which is the historical, now-deprecated behavior.
+func GetCertificateUser(
+	tlsState *tls.ConnectionState, idMap *CertificateIdentityMap,
+) (string, error) {
 	if tlsState == nil {
 		return "", errors.Errorf("request is not using TLS")
 	}
@@ -46,7 +55,11 @@ func GetCertificateUser(tlsState *tls.ConnectionState) (string, error) {
 		// TODO(marc): can this happen? Should we require exactly one?
 		return "", errors.Errorf("client cerficates not verified")
 	}
-	return tlsState.PeerCertificates[0].Subject.CommonName, nil
+	leaf := tlsState.PeerCertificates[0]
+	if user, ok := idMap.UserForCertificate(leaf); ok {
+		return user, nil
+	}
+	return leaf.Subject.CommonName, nil
 }
 
 // RequestWithUser must be implemented by `roachpb.Request`s which are
@@ -59,9 +72,12 @@ type RequestWithUser interface {
 // mode and client certificate.
 // The proto.Message passed to the hook must implement RequestWithUser.
 func ProtoAuthHook(
-	insecureMode bool, tlsState *tls.ConnectionState,
+	insecureMode bool,
+	tlsState *tls.ConnectionState,
+	idMap *CertificateIdentityMap,
+	revocationChecker *RevocationChecker,
 ) (func(proto.Message, bool) error, error) {
-	userHook, err := UserAuthCertHook(insecureMode, tlsState)
+	userHook, err := UserAuthCertHook(insecureMode, tlsState, idMap, revocationChecker)
 	if err != nil {
 		return nil, err
 	}
@@ -81,16 +97,38 @@ func ProtoAuthHook(
 }
 
 // UserAuthCertHook builds an authentication hook based on the security
-// mode and client certificate.
-func UserAuthCertHook(insecureMode bool, tlsState *tls.ConnectionState) (UserAuthHook, error) {
+// mode and client certificate. idMap, if non-nil, is consulted to derive the
+// certificate user from its Subject Alternative Names rather than its
+// CommonName; see GetCertificateUser. revocationChecker, if non-nil, is
+// consulted to reject certificates that have been revoked via CRL or OCSP.
+func UserAuthCertHook(
+	insecureMode bool,
+	tlsState *tls.ConnectionState,
+	idMap *CertificateIdentityMap,
+	revocationChecker *RevocationChecker,
+) (UserAuthHook, error) {
 	var certUser string
 
 	if !insecureMode {
 		var err error
-		certUser, err = GetCertificateUser(tlsState)
+		certUser, err = GetCertificateUser(tlsState, idMap)
 		if err != nil {
 			return nil, err
 		}
+		if revocationChecker != nil {
+			leaf := tlsState.PeerCertificates[0]
+			var issuer *x509.Certificate
+			if len(tlsState.VerifiedChains) > 0 && len(tlsState.VerifiedChains[0]) > 1 {
+				issuer = tlsState.VerifiedChains[0][1]
+			}
+			revoked, err := revocationChecker.IsRevoked(leaf, issuer)
+			if err != nil {
+				return nil, errors.Wrap(err, "checking certificate revocation")
+			}
+			if revoked {
+				return nil, errors.Errorf("client certificate for %s has been revoked", certUser)
+			}
+		}
 	}
 
 	return func(requestedUser string, clientConnection bool) error {
@@ -120,8 +158,14 @@ func UserAuthCertHook(insecureMode bool, tlsState *tls.ConnectionState) (UserAut
 }
 
 // UserAuthPasswordHook builds an authentication hook based on the security
-// mode, password, and its potentially matching hash.
-func UserAuthPasswordHook(insecureMode bool, password string, hashedPassword []byte) UserAuthHook {
+// mode, password, and the user's stored SCRAM-SHA-256 credential. Unlike the
+// prior bcrypt-based scheme, the salted/stretched verifier never needs to
+// leave this function: pgwire's full SCRAM wire exchange (see BeginSCRAM,
+// ContinueSCRAM, FinishSCRAM) never hands the cleartext password to the
+// server at all, but simpler callers that already have it in hand (e.g.
+// HTTP basic auth) can still verify it against the same stored credential
+// via CredentialMatchesPassword.
+func UserAuthPasswordHook(insecureMode bool, password string, credential StoredCredential) UserAuthHook {
 	return func(requestedUser string, clientConnection bool) error {
 		if len(requestedUser) == 0 {
 			return errors.New("user is missing")
@@ -140,7 +184,7 @@ func UserAuthPasswordHook(insecureMode bool, password string, hashedPassword []b
 		}
 
 		// If the requested user has an empty password, disallow authentication.
-		if len(password) == 0 || compareHashAndPassword(hashedPassword, password) != nil {
+		if len(password) == 0 || !CredentialMatchesPassword(credential, password) {
 			return errors.New("invalid password")
 		}
 
@@ -0,0 +1,157 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Identity is the result of a successful Authenticate call: the Cockroach
+// user the connection was authenticated as, and whether no Cockroach user
+// with that name exists yet (e.g. a first-time SSO login). Authenticators do
+// not create users themselves; NewlyMapped tells the caller that it may need
+// to.
+type Identity struct {
+	User        string
+	NewlyMapped bool
+}
+
+// AuthRequest bundles the information an Authenticator needs to decide
+// whether, and as whom, to authenticate an incoming connection. Not every
+// field is populated for every connection: TLSState is nil for connections
+// that never negotiated TLS, and BearerToken/Password are empty unless the
+// client supplied one.
+type AuthRequest struct {
+	// RequestedUser is the Cockroach user the client asked to connect as.
+	RequestedUser string
+	// ClientConnection is true for client-originated connections and false
+	// for intra-cluster, node-to-node connections.
+	ClientConnection bool
+	// TLSState is the negotiated TLS connection state, if any.
+	TLSState *tls.ConnectionState
+	// Password is the cleartext password supplied by the client, if any.
+	Password string
+	// BearerToken is an OIDC/OAuth2 bearer token supplied by the client, if
+	// any.
+	BearerToken string
+	// SCRAMClientFirstMessage and SCRAMClientFinalMessage are the two
+	// client-sent messages of a SCRAM-SHA-256 exchange (RFC 5802), if the
+	// client is authenticating with the "scram-sha-256" method. See
+	// SCRAMAuthenticator.
+	SCRAMClientFirstMessage string
+	SCRAMClientFinalMessage string
+}
+
+// Authenticator decides whether an incoming connection, described by an
+// AuthRequest, should be allowed to act as a Cockroach user. Implementations
+// are expected to be stateless with respect to a single request (any mutable
+// state, such as a revocation cache, must be safe for concurrent use).
+type Authenticator interface {
+	// Authenticate validates req and returns the Identity it authenticates
+	// as, or an error if authentication fails.
+	Authenticate(ctx context.Context, req AuthRequest) (Identity, error)
+	// RequiresClientCert returns true if this Authenticator can only
+	// validate connections that presented a verified client certificate.
+	RequiresClientCert() bool
+	// IsPasswordless returns true if this Authenticator does not check a
+	// user's password in any form, neither req.Password nor a SCRAM exchange
+	// (e.g. cert-based or OIDC authenticators).
+	IsPasswordless() bool
+}
+
+// authenticatorRegistry is a process-wide registry of named Authenticator
+// implementations, populated by RegisterAuthenticator at init time and
+// consulted by the server to assemble the chain configured for a cluster.
+var authenticatorRegistry = struct {
+	mu     sync.Mutex
+	byName map[string]func() Authenticator
+}{byName: make(map[string]func() Authenticator)}
+
+// RegisterAuthenticator makes an Authenticator implementation available
+// under name for use in an authenticator chain. It is typically called from
+// an init function. Registering the same name twice panics, since that
+// indicates a programming error rather than a runtime condition.
+func RegisterAuthenticator(name string, factory func() Authenticator) {
+	authenticatorRegistry.mu.Lock()
+	defer authenticatorRegistry.mu.Unlock()
+	if _, ok := authenticatorRegistry.byName[name]; ok {
+		panic(errors.Errorf("authenticator %q already registered", name))
+	}
+	authenticatorRegistry.byName[name] = factory
+}
+
+// NewAuthenticator constructs the Authenticator registered under name, or an
+// error if no such Authenticator is registered.
+func NewAuthenticator(name string) (Authenticator, error) {
+	authenticatorRegistry.mu.Lock()
+	factory, ok := authenticatorRegistry.byName[name]
+	authenticatorRegistry.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("unknown authenticator %q", name)
+	}
+	return factory(), nil
+}
+
+// AuthenticatorChain authenticates a request by trying each Authenticator in
+// order and returning the first successful Identity. This allows, for
+// example, an SSO-fronted deployment to accept both OIDC bearer tokens from
+// applications and client certificates from nodes, without giving up
+// cert-based node auth.
+type AuthenticatorChain []Authenticator
+
+// Authenticate implements the Authenticator interface.
+func (c AuthenticatorChain) Authenticate(ctx context.Context, req AuthRequest) (Identity, error) {
+	var lastErr error
+	for _, a := range c {
+		if a.RequiresClientCert() && (req.TLSState == nil || len(req.TLSState.PeerCertificates) == 0) {
+			continue
+		}
+		identity, err := a.Authenticate(ctx, req)
+		if err == nil {
+			return identity, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no authenticator configured")
+	}
+	return Identity{}, errors.Wrap(lastErr, "authentication failed")
+}
+
+// RequiresClientCert implements the Authenticator interface. A chain requires
+// a client cert only if every link in it does.
+func (c AuthenticatorChain) RequiresClientCert() bool {
+	for _, a := range c {
+		if !a.RequiresClientCert() {
+			return false
+		}
+	}
+	return len(c) > 0
+}
+
+// IsPasswordless implements the Authenticator interface. A chain is
+// passwordless only if every link in it is.
+func (c AuthenticatorChain) IsPasswordless() bool {
+	for _, a := range c {
+		if !a.IsPasswordless() {
+			return false
+		}
+	}
+	return true
+}
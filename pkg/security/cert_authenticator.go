@@ -0,0 +1,112 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// certAuthenticatorName is the name CertAuthenticator is registered under.
+const certAuthenticatorName = "cert"
+
+func init() {
+	RegisterAuthenticator(certAuthenticatorName, func() Authenticator { return &CertAuthenticator{} })
+}
+
+// CertAuthenticator is the Authenticator wrapping the existing
+// client-certificate auth flow (GetCertificateUser / UserAuthCertHook). It is
+// always insecure-aware: in insecure mode it does not examine a certificate,
+// and accepts any non-empty requested user on client connections (node
+// connections must still request NodeUser).
+type CertAuthenticator struct {
+	// InsecureMode mirrors the server's --insecure flag.
+	InsecureMode bool
+	// IdentityMap, if non-nil, maps SAN fields to users; see
+	// CertificateIdentityMap.
+	IdentityMap *CertificateIdentityMap
+	// RevocationChecker, if non-nil, rejects certificates revoked via CRL or
+	// OCSP; see RevocationChecker.
+	RevocationChecker *RevocationChecker
+}
+
+// Authenticate implements the Authenticator interface.
+func (a *CertAuthenticator) Authenticate(_ context.Context, req AuthRequest) (Identity, error) {
+	hook, err := UserAuthCertHook(a.InsecureMode, req.TLSState, a.IdentityMap, a.RevocationChecker)
+	if err != nil {
+		return Identity{}, err
+	}
+	if err := hook(req.RequestedUser, req.ClientConnection); err != nil {
+		return Identity{}, err
+	}
+	return Identity{User: req.RequestedUser}, nil
+}
+
+// RequiresClientCert implements the Authenticator interface.
+func (a *CertAuthenticator) RequiresClientCert() bool {
+	return !a.InsecureMode
+}
+
+// IsPasswordless implements the Authenticator interface.
+func (a *CertAuthenticator) IsPasswordless() bool {
+	return true
+}
+
+// passwordAuthenticatorName is the name PasswordAuthenticator is registered
+// under.
+const passwordAuthenticatorName = "password"
+
+func init() {
+	RegisterAuthenticator(passwordAuthenticatorName, func() Authenticator { return &PasswordAuthenticator{} })
+}
+
+// PasswordAuthenticator is the Authenticator wrapping the existing
+// UserAuthPasswordHook flow. The stored credential is resolved per request
+// through CredentialLookup (typically from system.users), since it depends on
+// RequestedUser.
+type PasswordAuthenticator struct {
+	// InsecureMode mirrors the server's --insecure flag.
+	InsecureMode bool
+	// CredentialLookup resolves the stored SCRAM-SHA-256 credential for a
+	// requested user.
+	CredentialLookup func(user string) (StoredCredential, error)
+}
+
+// Authenticate implements the Authenticator interface.
+func (a *PasswordAuthenticator) Authenticate(_ context.Context, req AuthRequest) (Identity, error) {
+	if a.CredentialLookup == nil {
+		return Identity{}, errors.New("password authenticator is not configured with a credential lookup")
+	}
+	credential, err := a.CredentialLookup(req.RequestedUser)
+	if err != nil {
+		return Identity{}, err
+	}
+	hook := UserAuthPasswordHook(a.InsecureMode, req.Password, credential)
+	if err := hook(req.RequestedUser, req.ClientConnection); err != nil {
+		return Identity{}, err
+	}
+	return Identity{User: req.RequestedUser}, nil
+}
+
+// RequiresClientCert implements the Authenticator interface.
+func (a *PasswordAuthenticator) RequiresClientCert() bool {
+	return false
+}
+
+// IsPasswordless implements the Authenticator interface.
+func (a *PasswordAuthenticator) IsPasswordless() bool {
+	return false
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package hba implements a small subsystem, modeled on PostgreSQL's
+// pg_hba.conf, for picking an authentication method per incoming connection
+// based on its connection type, target database, requested user, and source
+// address. See Parse and Conf.Match.
+package hba
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ConnType identifies the kind of connection an Entry applies to, mirroring
+// pg_hba.conf's "local"/"host"/"hostssl"/"hostnossl" record types. CockroachDB
+// has no Unix-socket ("local") connections, so only the host variants are
+// meaningful, but the type is kept symmetrical with postgres for operators
+// familiar with it.
+type ConnType string
+
+const (
+	// ConnHost matches any TCP connection, regardless of whether TLS was
+	// negotiated.
+	ConnHost ConnType = "host"
+	// ConnHostSSL matches only TCP connections that negotiated TLS.
+	ConnHostSSL ConnType = "hostssl"
+	// ConnHostNoSSL matches only TCP connections that did not negotiate TLS.
+	ConnHostNoSSL ConnType = "hostnossl"
+)
+
+// Method names the authentication method an Entry selects. The cert,
+// password, scram-sha-256 and oidc methods share their names with the
+// security.Authenticator implementations registered under them; trust and
+// reject have no registered Authenticator.
+type Method string
+
+const (
+	// MethodCert requires a verified client certificate.
+	MethodCert Method = "cert"
+	// MethodPassword authenticates with a cleartext password.
+	MethodPassword Method = "password"
+	// MethodSCRAMSHA256 authenticates with a SCRAM-SHA-256 exchange.
+	MethodSCRAMSHA256 Method = "scram-sha-256"
+	// MethodOIDC authenticates with an OIDC/OAuth2 bearer token.
+	MethodOIDC Method = "oidc"
+	// MethodTrust allows the connection without any further check.
+	MethodTrust Method = "trust"
+	// MethodReject always rejects the connection.
+	MethodReject Method = "reject"
+)
+
+// all is a magic value meaning "matches any database/user/address", mirroring
+// pg_hba.conf's "all" keyword.
+const all = "all"
+
+// Entry is a single parsed line of an hba.Conf: the first rule, in file
+// order, whose ConnType, Database, User and Address all match an incoming
+// connection determines its Method.
+type Entry struct {
+	ConnType ConnType
+	Database string
+	User     string
+	Address  *net.IPNet
+	Method   Method
+	// Input is the unparsed line with any '#' comment and surrounding
+	// whitespace removed, kept for SHOW hba_conf.
+	Input string
+}
+
+// Conf is an ordered list of Entry rules, modeled on pg_hba.conf.
+type Conf struct {
+	Entries []Entry
+}
+
+// Match returns the Method of the first Entry matching connType, database,
+// user and addr, or MethodReject with ok=false if no entry matches — pg_hba's
+// "implicit reject" default.
+func (c *Conf) Match(connType ConnType, database, user string, addr net.IP) (Method, bool) {
+	for _, e := range c.Entries {
+		if !entryMatchesConnType(e.ConnType, connType) {
+			continue
+		}
+		if e.Database != all && e.Database != database {
+			continue
+		}
+		if e.User != all && e.User != user {
+			continue
+		}
+		if e.Address != nil {
+			// A CIDR-scoped entry must not match when we don't know the
+			// source address: treating a missing addr as "matches anything"
+			// would let every CIDR-scoped rule be bypassed simply by not
+			// supplying one, silently failing open instead of closed.
+			if addr == nil || !e.Address.Contains(addr) {
+				continue
+			}
+		}
+		return e.Method, true
+	}
+	return MethodReject, false
+}
+
+// entryMatchesConnType reports whether an entry declared as entryType applies
+// to an incoming connection of kind connType. ConnHost matches both TLS and
+// non-TLS connections; ConnHostSSL/ConnHostNoSSL require an exact match.
+func entryMatchesConnType(entryType, connType ConnType) bool {
+	if entryType == ConnHost {
+		return true
+	}
+	return entryType == connType
+}
+
+// Parse reads an hba.Conf from its textual representation: one rule per
+// line, fields separated by whitespace, '#' starting a comment, in the form
+//
+//	<conn-type> <database> <user> <address> <method>
+//
+// e.g. "hostssl all root 0.0.0.0/0 cert" or "host all all 10.0.1.0/24
+// scram-sha-256". Blank lines and comment-only lines are ignored.
+func Parse(conf string) (*Conf, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(strings.NewReader(conf))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			return nil, errors.Errorf("line %d: expected 5 fields, got %d: %q", lineNum, len(fields), line)
+		}
+
+		entry, err := parseEntry(fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		entry.Input = line
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &Conf{Entries: entries}, nil
+}
+
+func parseEntry(fields []string) (Entry, error) {
+	connType := ConnType(fields[0])
+	switch connType {
+	case ConnHost, ConnHostSSL, ConnHostNoSSL:
+	default:
+		return Entry{}, errors.Errorf("unknown connection type %q", fields[0])
+	}
+
+	method := Method(fields[4])
+	switch method {
+	case MethodCert, MethodPassword, MethodSCRAMSHA256, MethodOIDC, MethodTrust, MethodReject:
+	default:
+		return Entry{}, errors.Errorf("unknown authentication method %q", fields[4])
+	}
+
+	var ipNet *net.IPNet
+	if addr := fields[3]; addr != all {
+		_, parsed, err := net.ParseCIDR(addr)
+		if err != nil {
+			// Allow a bare IP as a /32 (or /128) match.
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return Entry{}, errors.Wrapf(err, "invalid address %q", addr)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			_, parsed, _ = net.ParseCIDR(ip.String() + "/" + strconv.Itoa(bits))
+		}
+		ipNet = parsed
+	}
+
+	return Entry{
+		ConnType: connType,
+		Database: fields[1],
+		User:     fields[2],
+		Address:  ipNet,
+		Method:   method,
+	}, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package hba
+
+import (
+	"net"
+	"testing"
+)
+
+const testConf = `
+# comment line, and a blank line below
+
+hostssl all  root 0.0.0.0/0    cert
+host    all  all  10.0.1.0/24  scram-sha-256
+host    all  all  0.0.0.0/0    reject
+`
+
+func TestParseAndMatchPrecedence(t *testing.T) {
+	conf, err := Parse(testConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(conf.Entries))
+	}
+
+	// root from anywhere over TLS must hit the first (cert) rule, even
+	// though later rules would also match "all".
+	method, ok := conf.Match(ConnHostSSL, "defaultdb", "root", net.ParseIP("203.0.113.7"))
+	if !ok || method != MethodCert {
+		t.Fatalf("expected root over TLS to require cert, got %v, %v", method, ok)
+	}
+
+	// app traffic from the VPC hits the scram rule.
+	method, ok = conf.Match(ConnHost, "defaultdb", "app", net.ParseIP("10.0.1.42"))
+	if !ok || method != MethodSCRAMSHA256 {
+		t.Fatalf("expected VPC traffic to get scram-sha-256, got %v, %v", method, ok)
+	}
+
+	// Anything else falls through to the final reject-all.
+	method, ok = conf.Match(ConnHost, "defaultdb", "app", net.ParseIP("8.8.8.8"))
+	if !ok || method != MethodReject {
+		t.Fatalf("expected traffic outside the VPC to be rejected, got %v, %v", method, ok)
+	}
+}
+
+func TestMatchFailsClosedWithoutAddress(t *testing.T) {
+	conf, err := Parse("hostssl all all 10.0.0.0/24 cert\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No source address is known: a CIDR-scoped entry must not match, and
+	// since there is no fallback entry this must report no match at all.
+	if method, ok := conf.Match(ConnHostSSL, "defaultdb", "root", nil); ok {
+		t.Fatalf("expected no match with a nil address, got %v", method)
+	}
+}
+
+func TestMatchHostSSLRequiresTLS(t *testing.T) {
+	conf, err := Parse("hostssl all all 0.0.0.0/0 cert\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conf.Match(ConnHostNoSSL, "defaultdb", "root", net.ParseIP("127.0.0.1")); ok {
+		t.Fatal("a hostssl entry must not match a non-TLS connection")
+	}
+}
+
+func TestParseRejectsMalformedLines(t *testing.T) {
+	if _, err := Parse("hostssl all root cert\n"); err == nil {
+		t.Fatal("expected an error for a line with too few fields")
+	}
+	if _, err := Parse("hostssl all root 0.0.0.0/0 bogus-method\n"); err == nil {
+		t.Fatal("expected an error for an unknown method")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/pkg/security/hba"
+	"github.com/pkg/errors"
+)
+
+// HBAPolicy holds the cluster's current hba.Conf and makes it available for
+// atomic, lock-free reload. The zero value has no entries and rejects every
+// connection, matching pg_hba's implicit-reject default.
+//
+// The server is expected to expose a cluster setting (e.g.
+// "server.host_based_authentication.configuration") whose SetOnChange
+// callback calls SetConf with the newly parsed policy, and a
+// "SHOW hba_conf" builtin that renders Conf().Entries back to the user for
+// inspection.
+type HBAPolicy struct {
+	conf atomic.Value // *hba.Conf
+}
+
+// SetConf atomically installs conf as the policy consulted by future calls
+// to SelectAuthenticationMethod.
+func (p *HBAPolicy) SetConf(conf *hba.Conf) {
+	p.conf.Store(conf)
+}
+
+// Conf returns the policy's current hba.Conf, or an empty one if none has
+// been set yet.
+func (p *HBAPolicy) Conf() *hba.Conf {
+	if c, ok := p.conf.Load().(*hba.Conf); ok && c != nil {
+		return c
+	}
+	return &hba.Conf{}
+}
+
+// SelectAuthenticationMethod picks the hba.Method that applies to an
+// incoming connection described by connType, database, user and addr,
+// consulting the policy in priority order, so a single cluster can, for
+// example, require certs for root from anywhere while allowing SCRAM from an
+// app's VPC. ProtoAuthHook does not call it: it always builds a
+// client-certificate hook, split only by insecure vs. secure mode.
+func (p *HBAPolicy) SelectAuthenticationMethod(
+	connType hba.ConnType, database, user string, addr net.IP,
+) (hba.Method, error) {
+	method, ok := p.Conf().Match(connType, database, user, addr)
+	if !ok {
+		return hba.MethodReject, errors.Errorf(
+			"no hba.Conf entry matches user %q, database %q from %s; rejecting", user, database, addr)
+	}
+	return method, nil
+}
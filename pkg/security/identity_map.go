@@ -0,0 +1,132 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"crypto/x509"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// SANType identifies which Subject Alternative Name field a
+// CertificateIdentityRule matches against.
+type SANType int
+
+const (
+	// SANTypeDNS matches against the certificate's DNS name SANs.
+	SANTypeDNS SANType = iota
+	// SANTypeURI matches against the certificate's URI SANs (e.g. SPIFFE IDs).
+	SANTypeURI
+	// SANTypeEmail matches against the certificate's email SANs.
+	SANTypeEmail
+)
+
+// CertificateIdentityRule maps certificates whose Subject Alternative Names
+// match Pattern, and whose Organizational Unit (if AllowedOUs is non-empty)
+// is one of AllowedOUs, to the CockroachDB user named User.
+type CertificateIdentityRule struct {
+	SANType SANType
+	// Pattern must be anchored (e.g. via NewCertificateIdentityRule) so that
+	// it matches the whole SAN value; an unanchored pattern turns a rule
+	// meant to scope a trusted suffix into a substring match that any SAN
+	// value containing that suffix satisfies.
+	Pattern    *regexp.Regexp
+	User       string
+	AllowedOUs []string
+}
+
+// CertificateIdentityMap is an ordered list of rules used to derive a
+// CockroachDB username from a client certificate's Subject Alternative Names.
+// This lets a single CA issue SPIFFE/Kubernetes-style workload certificates
+// whose CommonName is meaningless (e.g. a random pod name) while still
+// mapping them to stable Cockroach users. Rules are evaluated in order and
+// the first match wins; if no rule matches, callers fall back to the
+// certificate's CommonName.
+type CertificateIdentityMap struct {
+	Rules []CertificateIdentityRule
+}
+
+// UserForCertificate returns the Cockroach user that cert maps to according
+// to m, and true if a rule matched. If m is nil or no rule matches, it
+// returns "", false.
+func (m *CertificateIdentityMap) UserForCertificate(cert *x509.Certificate) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	for _, rule := range m.Rules {
+		if len(rule.AllowedOUs) > 0 && !organizationalUnitAllowed(cert.Subject.OrganizationalUnit, rule.AllowedOUs) {
+			continue
+		}
+		switch rule.SANType {
+		case SANTypeDNS:
+			for _, name := range cert.DNSNames {
+				if rule.Pattern.MatchString(name) {
+					return rule.User, true
+				}
+			}
+		case SANTypeURI:
+			for _, uri := range cert.URIs {
+				if rule.Pattern.MatchString(uri.String()) {
+					return rule.User, true
+				}
+			}
+		case SANTypeEmail:
+			for _, email := range cert.EmailAddresses {
+				if rule.Pattern.MatchString(email) {
+					return rule.User, true
+				}
+			}
+		default:
+			continue
+		}
+	}
+	return "", false
+}
+
+// organizationalUnitAllowed returns true if any entry in certOUs is present
+// in allowed.
+func organizationalUnitAllowed(certOUs, allowed []string) bool {
+	for _, ou := range certOUs {
+		for _, a := range allowed {
+			if ou == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewCertificateIdentityRule compiles pattern and returns a
+// CertificateIdentityRule, or an error if pattern is not a valid regexp.
+// pattern is always anchored to match the whole SAN value: without this, a
+// rule like "node.*.crdb.internal" would match as an unanchored substring
+// search and let any workload that controls a DNS/URI/email SAN suffix (e.g.
+// "evilnode.crdb.internal.attacker.example.com") mint itself that rule's
+// user, defeating the point of deriving identity from the SAN.
+func NewCertificateIdentityRule(
+	sanType SANType, pattern, user string, allowedOUs ...string,
+) (CertificateIdentityRule, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return CertificateIdentityRule{}, errors.Wrapf(err, "invalid SAN pattern %q", pattern)
+	}
+	return CertificateIdentityRule{
+		SANType:    sanType,
+		Pattern:    re,
+		User:       user,
+		AllowedOUs: allowedOUs,
+	}, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestCertificateIdentityMapAnchorsPatterns(t *testing.T) {
+	rule, err := NewCertificateIdentityRule(SANTypeDNS, `node\..*\.crdb\.internal`, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &CertificateIdentityMap{Rules: []CertificateIdentityRule{rule}}
+
+	legit := &x509.Certificate{DNSNames: []string{"node.us-east-1.crdb.internal"}}
+	if user, ok := m.UserForCertificate(legit); !ok || user != "node" {
+		t.Fatalf("expected legitimate node cert to map to \"node\", got %q, %v", user, ok)
+	}
+
+	attacker := &x509.Certificate{
+		DNSNames: []string{"evilnode.crdb.internal.attacker.example.com"},
+	}
+	if user, ok := m.UserForCertificate(attacker); ok {
+		t.Fatalf("attacker-controlled SAN suffix must not map to a user, got %q", user)
+	}
+}
+
+func TestCertificateIdentityMapOUAllowlist(t *testing.T) {
+	rule, err := NewCertificateIdentityRule(SANTypeURI, `spiffe://cluster/ns/db/sa/app`, "app", "agents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &CertificateIdentityMap{Rules: []CertificateIdentityRule{rule}}
+
+	spiffeID, err := url.Parse("spiffe://cluster/ns/db/sa/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongOU := &x509.Certificate{
+		URIs:    []*url.URL{spiffeID},
+		Subject: pkix.Name{OrganizationalUnit: []string{"clients"}},
+	}
+	if user, ok := m.UserForCertificate(wrongOU); ok {
+		t.Fatalf("cert with disallowed OU must not match, got %q", user)
+	}
+
+	rightOU := &x509.Certificate{
+		URIs:    []*url.URL{spiffeID},
+		Subject: pkix.Name{OrganizationalUnit: []string{"agents"}},
+	}
+	if user, ok := m.UserForCertificate(rightOU); !ok || user != "app" {
+		t.Fatalf("cert with allowed OU should map to \"app\", got %q, %v", user, ok)
+	}
+}
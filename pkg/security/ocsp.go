@@ -0,0 +1,75 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// httpOCSPClient is the default OCSPClient, making real OCSP requests over
+// HTTP against the responder URL(s) found in a certificate's Authority
+// Information Access extension.
+type httpOCSPClient struct {
+	client *http.Client
+}
+
+// NewHTTPOCSPClient returns an OCSPClient that performs OCSP requests over
+// HTTP, using client for the actual request (http.DefaultClient if nil).
+func NewHTTPOCSPClient(client *http.Client) OCSPClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpOCSPClient{client: client}
+}
+
+// CheckStatus implements the OCSPClient interface.
+func (c *httpOCSPClient) CheckStatus(cert, issuer *x509.Certificate) (bool, error) {
+	if len(cert.OCSPServer) == 0 {
+		return false, errors.New("certificate has no OCSP responder URL")
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "building OCSP request")
+	}
+
+	var lastErr error
+	for _, responderURL := range cert.OCSPServer {
+		resp, err := c.client.Post(responderURL, "application/ocsp-request", bytes.NewReader(req))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		parsed, err := ocsp.ParseResponseForCert(body, cert, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return parsed.Status == ocsp.Revoked, nil
+	}
+	return false, errors.Wrap(lastErr, "all OCSP responders failed")
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// oidcAuthenticatorName is the name OIDCAuthenticator is registered under.
+const oidcAuthenticatorName = "oidc"
+
+func init() {
+	RegisterAuthenticator(oidcAuthenticatorName, func() Authenticator { return &OIDCAuthenticator{} })
+}
+
+// TokenVerifier validates a raw bearer token against a configured issuer and
+// returns the claims it carries. It is implemented by a wrapper around an
+// OIDC/OAuth2 library's ID-token or introspection verifier; it is an
+// interface here so tests can supply a fake without a network-reachable
+// issuer.
+type TokenVerifier interface {
+	// Verify checks the token's signature and standard claims (issuer,
+	// audience, expiry) and returns its subject and, if present, its email
+	// claim.
+	Verify(ctx context.Context, rawToken string) (subject, email string, err error)
+}
+
+// OIDCAuthenticator authenticates connections that present an OIDC/OAuth2
+// bearer token instead of a password or client certificate. The token's
+// subject or email claim (UseEmailClaim selects which) is mapped to a
+// Cockroach user; if UserMapper is nil, the claim value is used verbatim as
+// the username.
+type OIDCAuthenticator struct {
+	// Issuer is the OIDC issuer URL tokens are validated against.
+	Issuer string
+	// Verifier performs the actual signature and claim validation. Callers
+	// construct this once at startup from Issuer (e.g. by fetching the
+	// issuer's JWKS) and reuse it across connections.
+	Verifier TokenVerifier
+	// UseEmailClaim selects the "email" claim as the identity source instead
+	// of the default "sub" claim.
+	UseEmailClaim bool
+	// UserMapper maps a validated claim value to a Cockroach username. If
+	// nil, the claim value is used as-is.
+	UserMapper func(claim string) (user string, ok bool)
+	// UserExists reports whether user already exists as a Cockroach user.
+	// It is consulted only when DefaultRole is set, to decide whether an
+	// authenticated identity is "newly mapped" (see Identity.NewlyMapped) and
+	// so needs that role granted; with an empty DefaultRole, NewlyMapped is
+	// always false. Required whenever DefaultRole is non-empty.
+	UserExists func(user string) (bool, error)
+	// DefaultRole, if non-empty, names the role the caller should grant to
+	// users authenticated via OIDC who do not already exist as a Cockroach
+	// user (per UserExists), so that SSO-only deployments do not require
+	// pre-provisioning every user. Authenticate itself never creates users
+	// or grants roles; it only reports Identity.NewlyMapped so the caller
+	// (e.g. the pgwire connection setup) knows to do so.
+	DefaultRole string
+	// VerifyTimeout bounds how long a single token verification may take.
+	// Defaults to 5s if zero.
+	VerifyTimeout time.Duration
+}
+
+// Authenticate implements the Authenticator interface.
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, req AuthRequest) (Identity, error) {
+	if req.BearerToken == "" {
+		return Identity{}, errors.New("no bearer token presented")
+	}
+	if a.Verifier == nil {
+		return Identity{}, errors.Errorf("oidc authenticator for issuer %q is not configured with a verifier", a.Issuer)
+	}
+
+	timeout := a.VerifyTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	subject, email, err := a.Verifier.Verify(ctx, req.BearerToken)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "invalid bearer token")
+	}
+
+	claim := subject
+	if a.UseEmailClaim {
+		claim = email
+	}
+	if claim == "" {
+		return Identity{}, errors.New("token did not contain the configured identity claim")
+	}
+
+	user := claim
+	if a.UserMapper != nil {
+		mapped, ok := a.UserMapper(claim)
+		if !ok {
+			return Identity{}, errors.Errorf("no user mapping for claim %q", claim)
+		}
+		user = mapped
+	}
+
+	if req.RequestedUser != "" && req.RequestedUser != user {
+		return Identity{}, errors.Errorf("requested user is %s, but token maps to %s", req.RequestedUser, user)
+	}
+
+	var newlyMapped bool
+	if a.DefaultRole != "" {
+		if a.UserExists == nil {
+			return Identity{}, errors.New("oidc authenticator has a DefaultRole but no UserExists check configured")
+		}
+		exists, err := a.UserExists(user)
+		if err != nil {
+			return Identity{}, errors.Wrapf(err, "checking whether user %q exists", user)
+		}
+		newlyMapped = !exists
+	}
+
+	return Identity{User: user, NewlyMapped: newlyMapped}, nil
+}
+
+// RequiresClientCert implements the Authenticator interface.
+func (a *OIDCAuthenticator) RequiresClientCert() bool {
+	return false
+}
+
+// IsPasswordless implements the Authenticator interface.
+func (a *OIDCAuthenticator) IsPasswordless() bool {
+	return true
+}
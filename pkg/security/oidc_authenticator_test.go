@@ -0,0 +1,67 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeTokenVerifier struct {
+	subject string
+}
+
+func (f fakeTokenVerifier) Verify(context.Context, string) (string, string, error) {
+	return f.subject, "", nil
+}
+
+func TestOIDCAuthenticatorNewlyMapped(t *testing.T) {
+	existing := map[string]bool{"alice": true}
+
+	a := &OIDCAuthenticator{
+		Verifier:    fakeTokenVerifier{subject: "alice"},
+		DefaultRole: "sso-users",
+		UserExists: func(user string) (bool, error) {
+			return existing[user], nil
+		},
+	}
+
+	identity, err := a.Authenticate(context.Background(), AuthRequest{BearerToken: "tok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.NewlyMapped {
+		t.Fatalf("already-provisioned user must not be reported as newly mapped")
+	}
+
+	a.Verifier = fakeTokenVerifier{subject: "bob"}
+	identity, err = a.Authenticate(context.Background(), AuthRequest{BearerToken: "tok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.NewlyMapped {
+		t.Fatalf("unprovisioned user should be reported as newly mapped")
+	}
+}
+
+func TestOIDCAuthenticatorRequiresUserExistsWithDefaultRole(t *testing.T) {
+	a := &OIDCAuthenticator{
+		Verifier:    fakeTokenVerifier{subject: "alice"},
+		DefaultRole: "sso-users",
+	}
+	if _, err := a.Authenticate(context.Background(), AuthRequest{BearerToken: "tok"}); err == nil {
+		t.Fatal("expected error when DefaultRole is set without a UserExists check")
+	}
+}
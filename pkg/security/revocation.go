@@ -0,0 +1,223 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"crypto/x509"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RevocationCheckerMetrics holds counters exposed by a RevocationChecker.
+// Callers wire these into the server's metric registry; they are plain
+// counters here to keep this package free of a metrics-library dependency.
+type RevocationCheckerMetrics struct {
+	CacheHits          int64
+	CacheMisses        int64
+	RevokedRejections  int64
+	CRLRefreshFailures int64
+}
+
+// revocationCacheEntry records the revocation status last observed for a
+// certificate serial number, and when that observation expires.
+type revocationCacheEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+// RevocationChecker checks whether a client certificate has been revoked,
+// consulting one or more CRL files and/or OCSP responders named in the
+// certificate's Authority Information Access extension. Results are cached
+// for CacheTTL to bound the cost of checking every connection. A
+// RevocationChecker is safe for concurrent use.
+type RevocationChecker struct {
+	// CRLPaths lists local file paths containing DER or PEM encoded
+	// CertificateLists, reloaded every RefreshInterval.
+	CRLPaths []string
+	// RefreshInterval is how often CRLPaths are reloaded from disk. Defaults
+	// to 5 minutes if zero.
+	RefreshInterval time.Duration
+	// CacheTTL bounds how long a cert's revocation status is cached before
+	// it is re-checked. Defaults to 1 minute if zero.
+	CacheTTL time.Duration
+	// OCSPClient performs OCSP requests against the responder URL found in a
+	// certificate's AIA extension. It is an interface so tests can supply a
+	// fake without a network-reachable responder.
+	OCSPClient OCSPClient
+
+	Metrics RevocationCheckerMetrics
+
+	mu struct {
+		sync.Mutex
+		revokedSerials map[string]struct{} // from the most recently loaded CRLs
+		cache          map[string]revocationCacheEntry
+		// crlHealthy is false until CRLPaths has been loaded successfully at
+		// least once, and flips back to false on any later reload failure.
+		// While false, IsRevoked fails closed: a control whose entire purpose
+		// is rejecting previously-trusted certs must not silently degrade to
+		// trusting everyone just because a CRL file went missing or a reload
+		// raced a concurrent rotation.
+		crlHealthy bool
+	}
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// OCSPClient performs an OCSP status check for cert against its issuer.
+type OCSPClient interface {
+	// CheckStatus returns true if the OCSP responder reports cert as
+	// revoked.
+	CheckStatus(cert, issuer *x509.Certificate) (revoked bool, err error)
+}
+
+// NewRevocationChecker constructs a RevocationChecker and starts its
+// background CRL refresh loop. Callers must call Close when done.
+func NewRevocationChecker(crlPaths []string, ocspClient OCSPClient) *RevocationChecker {
+	c := &RevocationChecker{
+		CRLPaths:   crlPaths,
+		OCSPClient: ocspClient,
+		stopCh:     make(chan struct{}),
+	}
+	c.mu.revokedSerials = make(map[string]struct{})
+	c.mu.cache = make(map[string]revocationCacheEntry)
+	// No CRLs configured means there is nothing to fail closed about.
+	c.mu.crlHealthy = len(crlPaths) == 0
+	go c.refreshLoop()
+	return c
+}
+
+// Close stops the background CRL refresh loop.
+func (c *RevocationChecker) Close() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+func (c *RevocationChecker) refreshInterval() time.Duration {
+	if c.RefreshInterval > 0 {
+		return c.RefreshInterval
+	}
+	return 5 * time.Minute
+}
+
+func (c *RevocationChecker) cacheTTL() time.Duration {
+	if c.CacheTTL > 0 {
+		return c.CacheTTL
+	}
+	return time.Minute
+}
+
+func (c *RevocationChecker) refreshLoop() {
+	if err := c.reloadCRLs(); err != nil {
+		atomic.AddInt64(&c.Metrics.CRLRefreshFailures, 1)
+	}
+	ticker := time.NewTicker(c.refreshInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.reloadCRLs(); err != nil {
+				atomic.AddInt64(&c.Metrics.CRLRefreshFailures, 1)
+			}
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// reloadCRLs re-reads CRLPaths and rebuilds the in-memory revoked-serial set.
+// On failure, the previously loaded set (if any) is left untouched, but the
+// checker is marked unhealthy so IsRevoked fails closed rather than silently
+// enforcing nothing; see mu.crlHealthy.
+func (c *RevocationChecker) reloadCRLs() error {
+	revoked := make(map[string]struct{})
+	for _, path := range c.CRLPaths {
+		list, err := loadCRLFile(path)
+		if err != nil {
+			c.mu.Lock()
+			c.mu.crlHealthy = false
+			c.mu.Unlock()
+			return errors.Wrapf(err, "loading CRL %q", path)
+		}
+		for _, entry := range list.TBSCertList.RevokedCertificates {
+			revoked[entry.SerialNumber.String()] = struct{}{}
+		}
+	}
+	c.mu.Lock()
+	c.mu.revokedSerials = revoked
+	c.mu.crlHealthy = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Healthy reports whether CRLPaths has been loaded successfully at least
+// once since the last failure (or trivially true if CRLPaths is empty).
+// Callers are expected to surface this via a health check, since a false
+// result means the checker is currently failing every certificate closed.
+func (c *RevocationChecker) Healthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mu.crlHealthy
+}
+
+// IsRevoked reports whether cert has been revoked, consulting the cached CRL
+// set first and then, if configured and the cache has no fresh answer,
+// performing an OCSP request against issuer.
+func (c *RevocationChecker) IsRevoked(cert, issuer *x509.Certificate) (bool, error) {
+	serial := cert.SerialNumber.String()
+
+	if !c.Healthy() {
+		atomic.AddInt64(&c.Metrics.RevokedRejections, 1)
+		return false, errors.New("CRL checker is unhealthy (last reload failed); rejecting certificate to fail closed")
+	}
+
+	c.mu.Lock()
+	entry, ok := c.mu.cache[serial]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		atomic.AddInt64(&c.Metrics.CacheHits, 1)
+		if entry.revoked {
+			atomic.AddInt64(&c.Metrics.RevokedRejections, 1)
+		}
+		return entry.revoked, nil
+	}
+	atomic.AddInt64(&c.Metrics.CacheMisses, 1)
+
+	revoked := c.crlContains(serial)
+	if !revoked && c.OCSPClient != nil && issuer != nil {
+		var err error
+		revoked, err = c.OCSPClient.CheckStatus(cert, issuer)
+		if err != nil {
+			return false, errors.Wrap(err, "OCSP check failed")
+		}
+	}
+
+	c.mu.Lock()
+	c.mu.cache[serial] = revocationCacheEntry{revoked: revoked, expiresAt: time.Now().Add(c.cacheTTL())}
+	c.mu.Unlock()
+
+	if revoked {
+		atomic.AddInt64(&c.Metrics.RevokedRejections, 1)
+	}
+	return revoked, nil
+}
+
+func (c *RevocationChecker) crlContains(serial string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.mu.revokedSerials[serial]
+	return ok
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestChecker(crlPaths []string) *RevocationChecker {
+	c := &RevocationChecker{CRLPaths: crlPaths}
+	c.mu.revokedSerials = make(map[string]struct{})
+	c.mu.cache = make(map[string]revocationCacheEntry)
+	c.mu.crlHealthy = len(crlPaths) == 0
+	return c
+}
+
+func TestRevocationCheckerFailsClosedOnMissingCRL(t *testing.T) {
+	c := newTestChecker([]string{"/nonexistent/path/to.crl"})
+	if err := c.reloadCRLs(); err == nil {
+		t.Fatal("expected reloadCRLs to fail for a missing file")
+	}
+	if c.Healthy() {
+		t.Fatal("checker should be unhealthy after a failed CRL load")
+	}
+
+	cert := &x509.Certificate{SerialNumber: big.NewInt(42)}
+	revoked, err := c.IsRevoked(cert, nil)
+	if err == nil {
+		t.Fatal("expected IsRevoked to fail closed (return an error) while unhealthy")
+	}
+	if revoked {
+		t.Fatal("revoked should not be asserted true on a failed check; the error is what rejects the cert")
+	}
+}
+
+func TestRevocationCheckerNoCRLsConfiguredIsHealthy(t *testing.T) {
+	c := newTestChecker(nil)
+	if !c.Healthy() {
+		t.Fatal("a checker with no CRLPaths configured has nothing to fail closed about")
+	}
+	cert := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	if revoked, err := c.IsRevoked(cert, nil); err != nil || revoked {
+		t.Fatalf("expected not-revoked with no error, got revoked=%v err=%v", revoked, err)
+	}
+}
+
+func TestRevocationCheckerCachesResult(t *testing.T) {
+	c := newTestChecker(nil)
+	c.CacheTTL = time.Hour
+	c.mu.revokedSerials["7"] = struct{}{}
+
+	cert := &x509.Certificate{SerialNumber: big.NewInt(7)}
+	revoked, err := c.IsRevoked(cert, nil)
+	if err != nil || !revoked {
+		t.Fatalf("expected revoked=true, err=nil, got revoked=%v err=%v", revoked, err)
+	}
+	if c.Metrics.CacheMisses != 1 || c.Metrics.CacheHits != 0 {
+		t.Fatalf("expected one cache miss, got hits=%d misses=%d", c.Metrics.CacheHits, c.Metrics.CacheMisses)
+	}
+
+	// Remove the serial from the revoked set; a fresh cache entry should
+	// still report revoked until it expires.
+	delete(c.mu.revokedSerials, "7")
+	revoked, err = c.IsRevoked(cert, nil)
+	if err != nil || !revoked {
+		t.Fatalf("expected cached revoked=true, err=nil, got revoked=%v err=%v", revoked, err)
+	}
+	if c.Metrics.CacheHits != 1 {
+		t.Fatalf("expected one cache hit, got %d", c.Metrics.CacheHits)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// clientProofFor computes the SCRAM client proof a conforming client would
+// send, so tests can drive ContinueSCRAM without a real client library.
+func clientProofFor(password string, credential StoredCredential, authMessage string) string {
+	saltedPassword := pbkdf2.Key([]byte(password), credential.Salt, credential.Iterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+	clientSignature := hmacSHA256(credential.StoredKey, []byte(authMessage))
+	proof := make([]byte, len(clientKey))
+	for i := range proof {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	return base64.StdEncoding.EncodeToString(proof)
+}
+
+func TestSCRAMRoundTrip(t *testing.T) {
+	const password = "correct-horse-battery-staple"
+	credential := newStoredCredential(password, []byte("0123456789abcdef"), DefaultSCRAMIterationCount)
+
+	clientFirstMessageBare := "n=bob,r=clientnonce123"
+	ex, serverFirstMessage, err := BeginSCRAM("bob", credential, clientFirstMessageBare)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, err := parseSCRAMAttr(serverFirstMessage, 'r')
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientFinalMessageWithoutProof := "c=biws,r=" + nonce
+	authMessage := clientFirstMessageBare + "," + serverFirstMessage + "," + clientFinalMessageWithoutProof
+	proof := clientProofFor(password, credential, authMessage)
+	clientFinalMessage := clientFinalMessageWithoutProof + ",p=" + proof
+
+	serverFinalMessage, err := ContinueSCRAM(ex, clientFinalMessage)
+	if err != nil {
+		t.Fatalf("expected successful SCRAM exchange, got %v", err)
+	}
+	if !strings.HasPrefix(serverFinalMessage, "v=") {
+		t.Fatalf("expected server-final-message to start with \"v=\", got %q", serverFinalMessage)
+	}
+
+	expectedSignature := hmacSHA256(credential.ServerKey, []byte(authMessage))
+	gotSignature, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(serverFinalMessage, "v="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hmac.Equal(expectedSignature, gotSignature) {
+		t.Fatal("server signature does not match expected value")
+	}
+}
+
+func TestSCRAMWrongPasswordRejected(t *testing.T) {
+	credential := newStoredCredential("right-password", []byte("0123456789abcdef"), DefaultSCRAMIterationCount)
+
+	clientFirstMessageBare := "n=bob,r=clientnonce123"
+	ex, serverFirstMessage, err := BeginSCRAM("bob", credential, clientFirstMessageBare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := parseSCRAMAttr(serverFirstMessage, 'r')
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientFinalMessageWithoutProof := "c=biws,r=" + nonce
+	authMessage := clientFirstMessageBare + "," + serverFirstMessage + "," + clientFinalMessageWithoutProof
+	proof := clientProofFor("wrong-password", credential, authMessage)
+	clientFinalMessage := clientFinalMessageWithoutProof + ",p=" + proof
+
+	if _, err := ContinueSCRAM(ex, clientFinalMessage); err == nil {
+		t.Fatal("expected ContinueSCRAM to reject a proof derived from the wrong password")
+	}
+}
+
+// TestSCRAMMalformedProofDoesNotPanic reproduces the reported DoS: an
+// oversized, attacker-controlled "p=" field used to panic inside xorBytes
+// with an index-out-of-range instead of returning a normal SCRAM error.
+func TestSCRAMMalformedProofDoesNotPanic(t *testing.T) {
+	credential := newStoredCredential("irrelevant", []byte("0123456789abcdef"), DefaultSCRAMIterationCount)
+
+	clientFirstMessageBare := "n=bob,r=clientnonce123"
+	ex, serverFirstMessage, err := BeginSCRAM("bob", credential, clientFirstMessageBare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := parseSCRAMAttr(serverFirstMessage, 'r')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oversizedProof := base64.StdEncoding.EncodeToString(make([]byte, 200))
+	clientFinalMessage := "c=biws,r=" + nonce + ",p=" + oversizedProof
+
+	if _, err := ContinueSCRAM(ex, clientFinalMessage); err == nil {
+		t.Fatal("expected an error for an oversized client proof, got nil")
+	}
+}